@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -16,14 +18,16 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
+	"golang.org/x/sync/errgroup"
 )
 
 // https://developer.atlassian.com/bamboodev/rest-apis/bamboo-rest-resources
 
 type Exporter struct {
-	URI        string
-	HTTPClient *http.Client
-	Auth       struct {
+	URI         string
+	HTTPClient  *http.Client
+	Parallelism int
+	Auth        struct {
 		UserName string
 		Password string
 	}
@@ -33,6 +37,28 @@ type Exporter struct {
 	agentCountTotal prometheus.Gauge
 	agentCountBusy  prometheus.Gauge
 	buildQueue      prometheus.Gauge
+	results         *resultsCollector
+
+	scrapeErrors        *prometheus.CounterVec
+	scrapeDuration      *prometheus.HistogramVec
+	lastScrapeTimestamp prometheus.Gauge
+}
+
+// contextCollector binds a request-scoped context to an Exporter so that
+// concurrent scrapes of the same Exporter each use their own deadline
+// instead of racing over shared mutable state. A fresh contextCollector is
+// registered with a throwaway *prometheus.Registry per incoming request.
+type contextCollector struct {
+	exp *Exporter
+	ctx context.Context
+}
+
+func (c *contextCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.exp.Describe(ch)
+}
+
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exp.collect(c.ctx, ch)
 }
 
 type BambooAgent struct {
@@ -46,10 +72,10 @@ type BambooAgent struct {
 
 type BambooAgents []BambooAgent
 
-func (e Exporter) GetAgents() (BambooAgents, error) {
+func (e *Exporter) GetAgents(ctx context.Context) (BambooAgents, error) {
 	var output BambooAgents
 
-	request, err := e.Do("/rest/api/latest/agent")
+	request, err := e.Do(ctx, "agent", "/rest/api/latest/agent")
 	if err != nil {
 		return output, err
 	}
@@ -69,10 +95,10 @@ type BambooQueue struct {
 	} `json:"queuedBuilds"`
 }
 
-func (e Exporter) GetQueue() (BambooQueue, error) {
+func (e *Exporter) GetQueue(ctx context.Context) (BambooQueue, error) {
 	var output BambooQueue
 
-	request, err := e.Do("/rest/api/latest/queue")
+	request, err := e.Do(ctx, "queue", "/rest/api/latest/queue")
 	if err != nil {
 		return output, err
 	}
@@ -90,10 +116,10 @@ type BambooVersion struct {
 	Version string `json:"version"`
 }
 
-func (e Exporter) GetVersion() (BambooVersion, error) {
+func (e *Exporter) GetVersion(ctx context.Context) (BambooVersion, error) {
 	var output BambooVersion
 
-	request, err := e.Do("/rest/api/latest/info")
+	request, err := e.Do(ctx, "info", "/rest/api/latest/info")
 	if err != nil {
 		return output, err
 	}
@@ -105,9 +131,22 @@ func (e Exporter) GetVersion() (BambooVersion, error) {
 	return output, err
 }
 
-func (e Exporter) Do(endpoint string) (output []byte, err error) {
+func (e *Exporter) Do(ctx context.Context, name, endpoint string) (output []byte, err error) {
+
+	start := time.Now()
+	defer func() {
+		e.scrapeDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		if err != nil {
+			e.scrapeErrors.WithLabelValues(name).Inc()
+		}
+	}()
+
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
 
-	request, err := http.NewRequest("GET", e.URI+endpoint+"?os_authType=basic", nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", e.URI+endpoint+separator+"os_authType=basic", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -140,27 +179,80 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.agentCountTotal.Describe(ch)
 	e.agentCountBusy.Describe(ch)
 	e.buildQueue.Describe(ch)
+	if e.results.enabled {
+		e.results.Describe(ch)
+	}
+	e.scrapeErrors.Describe(ch)
+	e.scrapeDuration.Describe(ch)
+	e.lastScrapeTimestamp.Describe(ch)
 }
 
+// Collect satisfies prometheus.Collector for callers that don't need a
+// bounded, request-scoped context (e.g. self-registration checks). Scrape
+// handlers should instead register a contextCollector wrapping e so that
+// concurrent scrapes don't share a single cancellation.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.collect(context.Background(), ch)
+}
+
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
 
 	e.up.Set(1)
 
-	e.isRunning.Set(0)
-	version, err := e.GetVersion()
+	// A plain errgroup.Group, not errgroup.WithContext: the four endpoints
+	// are independent, and one of them failing must not cancel the others'
+	// in-flight requests (that would misreport them as broken too). Only
+	// the caller-supplied ctx bounds these calls.
+	var group errgroup.Group
+	if e.Parallelism > 0 {
+		group.SetLimit(e.Parallelism)
+	}
+
+	var version BambooVersion
+	group.Go(func() error {
+		var err error
+		version, err = e.GetVersion(ctx)
+
+		return err
+	})
+
+	var agentList BambooAgents
+	group.Go(func() error {
+		var err error
+		agentList, err = e.GetAgents(ctx)
+
+		return err
+	})
+
+	var buildQueue BambooQueue
+	group.Go(func() error {
+		var err error
+		buildQueue, err = e.GetQueue(ctx)
+
+		return err
+	})
+
+	var results BambooResults
+	if e.results.enabled {
+		group.Go(func() error {
+			var err error
+			results, err = e.GetResults(ctx, e.results.maxResults)
+
+			return err
+		})
+	}
+
+	err := group.Wait()
 	if err != nil {
 		log.Errorf("Can't scrape bamboo: %v", err)
 	}
+
+	e.isRunning.Set(0)
 	if strings.ToLower(version.State) == "running" {
 		e.isRunning.Set(1)
 	}
 	ch <- e.isRunning
 
-	e.agentCountTotal.Set(0)
-	agentList, err := e.GetAgents()
-	if err != nil {
-		log.Errorf("Can't scrape bamboo: %v", err)
-	}
 	e.agentCountTotal.Set(float64(len(agentList)))
 	ch <- e.agentCountTotal
 
@@ -204,20 +296,24 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.agentCountBusy.Set(busyAgents)
 	ch <- e.agentCountBusy
 
-	e.buildQueue.Set(0)
-	buildQueue, err := e.GetQueue()
-	if err != nil {
-		log.Errorf("Can't scrape bamboo: %v", err)
-	}
-
 	e.buildQueue.Set(float64(buildQueue.QueuedBuilds.Size))
 	ch <- e.buildQueue
 
+	if e.results.enabled {
+		e.results.observe(ch, results)
+	}
+
 	if err != nil {
 		e.up.Set(0)
 	}
 	ch <- e.up
 
+	e.lastScrapeTimestamp.Set(float64(time.Now().Unix()))
+	ch <- e.lastScrapeTimestamp
+
+	e.scrapeErrors.Collect(ch)
+	e.scrapeDuration.Collect(ch)
+
 	return
 }
 
@@ -227,12 +323,21 @@ var (
 	version    = "dev"
 	versionUrl = "https://github.com/wakeful/bamboo_exporter"
 
-	showVersion   = flag.Bool("version", false, "show version and exit")
-	uri           = flag.String("uri", "http://bamboo-uri", "bamboo uri")
-	userName      = flag.String("user", "root", "bamboo user name")
-	userPassword  = flag.String("password", "1234", "bamboo user password")
-	listenAddress = flag.String("listen-address", ":8080", "Address on which to expose metrics.")
-	metricsPath   = flag.String("telemetry-path", "/metrics", "Path under which to expose metrics.")
+	showVersion          = flag.Bool("version", false, "show version and exit")
+	uri                  = flag.String("uri", "http://bamboo-uri", "bamboo uri")
+	userName             = flag.String("user", "root", "bamboo user name")
+	userPassword         = flag.String("password", "1234", "bamboo user password")
+	listenAddress        = flag.String("listen-address", ":8080", "Address on which to expose metrics.")
+	metricsPath          = flag.String("telemetry-path", "/metrics", "Path under which to expose metrics.")
+	webConfigFile        = flag.String("web.config.file", "", "[EXPERIMENTAL] Path to configuration file that can enable TLS or basic auth.")
+	webTLSMinVersion     = flag.String("web.tls-min-version", "TLS12", "Minimum TLS version accepted when --web.config.file configures TLS (TLS10, TLS11, TLS12, TLS13).")
+	scrapeTimeout        = flag.Duration("scrape.timeout", 3*time.Second, "Timeout for a single scrape of the bamboo REST endpoints.")
+	scrapeParallelism    = flag.Int("scrape.parallelism", 3, "Maximum number of bamboo REST endpoints to scrape concurrently.")
+	collectResults       = flag.Bool("collect.results", false, "collect per-plan build result metrics from /rest/api/latest/result")
+	collectResultsMaxAge = flag.Duration("collect.results.max-age", 24*time.Hour, "ignore build results older than this when --collect.results is enabled")
+	collectResultsPlans  = flag.String("collect.results.plans", "", "regex used to filter which plan keys are collected when --collect.results is enabled")
+	collectResultsMax    = flag.Int("collect.results.max-results", 50, "maximum number of build results to page through per scrape when --collect.results is enabled")
+	configFile           = flag.String("config.file", "", "path to a config file mapping auth module names to credentials; enables multi-target /probe mode")
 
 	buildAgent = prometheus.NewDesc(
 		prometheus.BuildFQName(nameSpace, "agent", "busy"),
@@ -245,47 +350,84 @@ var supportedSchema = map[string]bool{
 	"https": true,
 }
 
-func NewExporter(uri, user, password string) *Exporter {
-	return &Exporter{uri,
-		&http.Client{
-			Timeout: 3 * time.Second,
+func NewExporter(uri, user, password string, scrapeTimeout time.Duration, parallelism int, results *resultsCollector) *Exporter {
+	exporter := &Exporter{
+		URI: uri,
+		HTTPClient: &http.Client{
+			Timeout: scrapeTimeout,
 		},
-		struct {
-			UserName string
-			Password string
-		}{
-			UserName: user,
-			Password: password,
-		},
-		prometheus.NewGauge(prometheus.GaugeOpts{
+		Parallelism: parallelism,
+		results:     results,
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: nameSpace,
 			Name:      "up",
 			Help:      "was the last scrape of bamboo successful?",
 		}),
-		prometheus.NewGauge(prometheus.GaugeOpts{
+		isRunning: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: nameSpace,
 			Name:      "running",
 			Help:      "is bamboo running?",
 		}),
-		prometheus.NewGauge(prometheus.GaugeOpts{
+		agentCountTotal: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: nameSpace,
 			Subsystem: "agent",
 			Name:      "count_total",
 			Help:      "number of build agents",
 		}),
-		prometheus.NewGauge(prometheus.GaugeOpts{
+		agentCountBusy: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: nameSpace,
 			Subsystem: "agent",
 			Name:      "count_busy",
 			Help:      "number of busy build agents",
 		}),
-		prometheus.NewGauge(prometheus.GaugeOpts{
+		buildQueue: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: nameSpace,
 			Subsystem: "queue",
 			Name:      "count",
 			Help:      "number of jobs in build queue",
 		}),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: nameSpace,
+			Subsystem: "exporter",
+			Name:      "scrape_errors_total",
+			Help:      "number of errors while scraping a bamboo REST endpoint",
+		}, []string{"endpoint"}),
+		scrapeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: nameSpace,
+			Subsystem: "exporter",
+			Name:      "scrape_duration_seconds",
+			Help:      "time taken to scrape a bamboo REST endpoint",
+		}, []string{"endpoint"}),
+		lastScrapeTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: nameSpace,
+			Subsystem: "exporter",
+			Name:      "last_scrape_timestamp_seconds",
+			Help:      "unix timestamp of the last scrape of bamboo",
+		}),
 	}
+
+	exporter.Auth.UserName = user
+	exporter.Auth.Password = password
+
+	return exporter
+}
+
+// scrapeHandler derives a bounded context from each incoming scrape request
+// and registers a contextCollector bound to it on a throwaway registry, so
+// that overlapping scrapes of the same exporter never share a cancellation.
+func scrapeHandler(exp *Exporter, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&contextCollector{exp: exp, ctx: ctx})
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+			ErrorHandling: promhttp.ContinueOnError,
+			Timeout:       timeout,
+		}).ServeHTTP(w, r)
+	})
 }
 
 func main() {
@@ -296,33 +438,64 @@ func main() {
 		os.Exit(2)
 	}
 
-	if *uri == "" || *userName == "" || *userPassword == "" {
-		log.Errorln("uri, user & password are mandatory")
-		os.Exit(2)
+	var planFilter *regexp.Regexp
+	if *collectResultsPlans != "" {
+		var err error
+		planFilter, err = regexp.Compile(*collectResultsPlans)
+		if err != nil {
+			log.Errorf("invalid --collect.results.plans regex: %v", err)
+			os.Exit(2)
+		}
 	}
 
-	parseURI, err := url.Parse(*uri)
-	if err != nil {
-		log.Errorf("%v", err)
-		os.Exit(1)
-	}
-	if !supportedSchema[parseURI.Scheme] {
-		log.Error("schema not supported")
-		os.Exit(2)
-	}
+	mux := http.NewServeMux()
 
-	log.Infof("starting bamboo_exporter for uri: %s on %s", *uri, *listenAddress)
-	exp := NewExporter(*uri, *userName, *userPassword)
+	if *configFile != "" {
+		probeConfig, err := loadProbeConfig(*configFile)
+		if err != nil {
+			log.Errorf("can't load --config.file: %v", err)
+			os.Exit(1)
+		}
 
-	prometheus.Unregister(prometheus.NewGoCollector())
-	prometheus.Unregister(prometheus.NewProcessCollector(os.Getegid(), ""))
-	prometheus.MustRegister(exp)
+		log.Infof("starting bamboo_exporter in multi-target mode on %s", *listenAddress)
+		mux.Handle("/probe", newProbeHandler(probeConfig, *scrapeTimeout, *scrapeParallelism,
+			*collectResults, *collectResultsMaxAge, *collectResultsMax, planFilter))
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/probe", http.StatusMovedPermanently)
+		})
+	} else {
+		if *uri == "" || *userName == "" || *userPassword == "" {
+			log.Errorln("uri, user & password are mandatory")
+			os.Exit(2)
+		}
 
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, *metricsPath, http.StatusMovedPermanently)
-	})
+		parseURI, err := url.Parse(*uri)
+		if err != nil {
+			log.Errorf("%v", err)
+			os.Exit(1)
+		}
+		if !supportedSchema[parseURI.Scheme] {
+			log.Error("schema not supported")
+			os.Exit(2)
+		}
+
+		results := newResultsCollector(*collectResults, *collectResultsMaxAge, *collectResultsMax, planFilter)
+
+		log.Infof("starting bamboo_exporter for uri: %s on %s", *uri, *listenAddress)
+		exp := NewExporter(*uri, *userName, *userPassword, *scrapeTimeout, *scrapeParallelism, results)
+
+		mux.Handle(*metricsPath, scrapeHandler(exp, *scrapeTimeout))
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, *metricsPath, http.StatusMovedPermanently)
+		})
+	}
+
+	server := &webConfigServer{
+		listenAddress: *listenAddress,
+		configFile:    *webConfigFile,
+		tlsMinVersion: *webTLSMinVersion,
+	}
 
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	log.Fatal(server.listenAndServe(mux))
 
 }
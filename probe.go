@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v2"
+)
+
+// ProbeModule is a named set of bamboo credentials, selected via the
+// /probe?auth=<name> query parameter.
+type ProbeModule struct {
+	UserName string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// ProbeConfig is the structure of the --config.file used in multi-target mode.
+type ProbeConfig struct {
+	Modules map[string]ProbeModule `yaml:"auth_modules"`
+}
+
+// loadProbeConfig reads and parses the --config.file YAML document.
+func loadProbeConfig(path string) (*ProbeConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ProbeConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// probeHandler serves /probe in multi-target mode: it builds a fresh
+// Exporter per request, scoped to the requested target and auth module,
+// and hands it off to a throwaway promhttp registry.
+type probeHandler struct {
+	config *ProbeConfig
+
+	scrapeTimeout     time.Duration
+	scrapeParallelism int
+
+	collectResults    bool
+	resultsMaxAge     time.Duration
+	resultsMaxResults int
+	planFilter        *regexp.Regexp
+
+	resultsMu  sync.Mutex
+	resultsFor map[string]*resultsCollector
+}
+
+func newProbeHandler(config *ProbeConfig, scrapeTimeout time.Duration, scrapeParallelism int,
+	collectResults bool, resultsMaxAge time.Duration, resultsMaxResults int, planFilter *regexp.Regexp) *probeHandler {
+	return &probeHandler{
+		config:            config,
+		scrapeTimeout:     scrapeTimeout,
+		scrapeParallelism: scrapeParallelism,
+		collectResults:    collectResults,
+		resultsMaxAge:     resultsMaxAge,
+		resultsMaxResults: resultsMaxResults,
+		planFilter:        planFilter,
+		resultsFor:        map[string]*resultsCollector{},
+	}
+}
+
+// resultsCollectorFor returns the resultsCollector for target+authModule,
+// creating it on first use, so bamboo_build_results_total stays a
+// monotonically increasing counter across scrapes instead of resetting to
+// zero on every /probe request.
+func (p *probeHandler) resultsCollectorFor(target, authModule string) *resultsCollector {
+	key := target + "|" + authModule
+
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+
+	results, ok := p.resultsFor[key]
+	if !ok {
+		results = newResultsCollector(p.collectResults, p.resultsMaxAge, p.resultsMaxResults, p.planFilter)
+		p.resultsFor[key] = results
+	}
+
+	return results
+}
+
+func (p *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+
+		return
+	}
+
+	parsedTarget, err := url.Parse(target)
+	if err != nil || !supportedSchema[parsedTarget.Scheme] {
+		http.Error(w, "target is not a valid http(s) uri", http.StatusBadRequest)
+
+		return
+	}
+
+	authModule := r.URL.Query().Get("auth")
+
+	module, ok := p.config.Modules[authModule]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown auth module %q", authModule), http.StatusBadRequest)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), p.scrapeTimeout)
+	defer cancel()
+
+	results := p.resultsCollectorFor(target, authModule)
+	exp := NewExporter(target, module.UserName, module.Password, p.scrapeTimeout, p.scrapeParallelism, results)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&contextCollector{exp: exp, ctx: ctx})
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(w, r)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// bambooTimeLayout is the ISO-8601 format Bamboo's REST API renders
+// timestamps in, e.g. "2016-05-24T13:05:00.000+02:00".
+const bambooTimeLayout = "2006-01-02T15:04:05.000-07:00"
+
+// BambooResult is a single entry returned by /rest/api/latest/result.
+type BambooResult struct {
+	PlanKey                string  `json:"key"`
+	PlanName               string  `json:"planName"`
+	ProjectKey             string  `json:"projectKey"`
+	ProjectName            string  `json:"projectName"`
+	State                  string  `json:"state"`
+	BuildNumber            int64   `json:"buildNumber"`
+	BuildDurationInSeconds float64 `json:"buildDurationInSeconds"`
+	BuildCompletedTime     string  `json:"buildCompletedTime"` // ISO-8601, e.g. "2016-05-24T13:05:00.000+02:00"
+}
+
+// completedTime parses BuildCompletedTime, returning the zero time if it's
+// empty or not in Bamboo's expected layout.
+func (b BambooResult) completedTime() time.Time {
+	if b.BuildCompletedTime == "" {
+		return time.Time{}
+	}
+
+	completed, err := time.Parse(bambooTimeLayout, b.BuildCompletedTime)
+	if err != nil {
+		log.Errorf("can't parse buildCompletedTime %q for %s: %v", b.BuildCompletedTime, b.PlanKey, err)
+
+		return time.Time{}
+	}
+
+	return completed
+}
+
+// BambooResults is the envelope Bamboo wraps a page of results in.
+type BambooResults struct {
+	Results struct {
+		Size   int64          `json:"size"`
+		Result []BambooResult `json:"result"`
+	} `json:"results"`
+}
+
+// resultsPageSize is the number of results requested per page while paging
+// through /rest/api/latest/result, matching Bamboo's own default.
+const resultsPageSize = 25
+
+// GetResults pages through /rest/api/latest/result, newest first, until
+// either maxResults have been collected or Bamboo reports no more pages.
+func (e *Exporter) GetResults(ctx context.Context, maxResults int) (BambooResults, error) {
+	var output BambooResults
+
+	for startIndex := 0; len(output.Results.Result) < maxResults; {
+		pageSize := resultsPageSize
+		if remaining := maxResults - len(output.Results.Result); remaining < pageSize {
+			pageSize = remaining
+		}
+
+		endpoint := fmt.Sprintf("/rest/api/latest/result?max-result=%d&start-index=%d&expand=results.result",
+			pageSize, startIndex)
+
+		request, err := e.Do(ctx, "result", endpoint)
+		if err != nil {
+			return output, err
+		}
+
+		var page BambooResults
+		if err := json.Unmarshal(request, &page); err != nil {
+			return output, err
+		}
+
+		output.Results.Result = append(output.Results.Result, page.Results.Result...)
+		output.Results.Size = page.Results.Size
+
+		startIndex += len(page.Results.Result)
+		if len(page.Results.Result) < pageSize || int64(startIndex) >= page.Results.Size {
+			break
+		}
+	}
+
+	return output, nil
+}
+
+var (
+	buildLastStatus = prometheus.NewDesc(
+		prometheus.BuildFQName(nameSpace, "build", "last_status"),
+		"state of the most recent build of a plan",
+		[]string{"plan", "project", "state"}, nil)
+)
+
+// resultsCollector tracks per-plan result counters across scrapes and holds
+// the gauges/counters exposed when --collect.results is enabled.
+type resultsCollector struct {
+	enabled    bool
+	maxAge     time.Duration
+	maxResults int
+	planFilter *regexp.Regexp
+
+	lastDuration *prometheus.GaugeVec
+	lastSuccess  *prometheus.GaugeVec
+	resultsTotal *prometheus.CounterVec
+
+	mu            sync.Mutex
+	seenBuildNums map[string]int64
+}
+
+func newResultsCollector(enabled bool, maxAge time.Duration, maxResults int, planFilter *regexp.Regexp) *resultsCollector {
+	return &resultsCollector{
+		enabled:    enabled,
+		maxAge:     maxAge,
+		maxResults: maxResults,
+		planFilter: planFilter,
+
+		lastDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: nameSpace,
+			Subsystem: "build",
+			Name:      "last_duration_seconds",
+			Help:      "duration of the most recent build of a plan",
+		}, []string{"plan", "project"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: nameSpace,
+			Subsystem: "build",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "timestamp of the most recent successful build of a plan",
+		}, []string{"plan", "project"}),
+		resultsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: nameSpace,
+			Subsystem: "build",
+			Name:      "results_total",
+			Help:      "total number of build results observed, by plan and state",
+		}, []string{"plan", "state"}),
+
+		seenBuildNums: map[string]int64{},
+	}
+}
+
+func (r *resultsCollector) Describe(ch chan<- *prometheus.Desc) {
+	r.lastDuration.Describe(ch)
+	r.lastSuccess.Describe(ch)
+	r.resultsTotal.Describe(ch)
+	ch <- buildLastStatus
+}
+
+// observe folds a page of build results into the collector's gauges and
+// counters, skipping plans excluded by planFilter and builds older than
+// maxAge, and counting each not-yet-seen build exactly once.
+func (r *resultsCollector) observe(ch chan<- prometheus.Metric, results BambooResults) {
+	latestByPlan := map[string]BambooResult{}
+	latestSuccessByPlan := map[string]time.Time{}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, result := range results.Results.Result {
+		if r.planFilter != nil && !r.planFilter.MatchString(result.PlanKey) {
+			continue
+		}
+
+		completed := result.completedTime()
+		if r.maxAge > 0 && time.Since(completed) > r.maxAge {
+			continue
+		}
+
+		if current, ok := latestByPlan[result.PlanKey]; !ok || result.BuildNumber > current.BuildNumber {
+			latestByPlan[result.PlanKey] = result
+		}
+
+		if strings.EqualFold(result.State, "Successful") {
+			if completed.After(latestSuccessByPlan[result.PlanKey]) {
+				latestSuccessByPlan[result.PlanKey] = completed
+			}
+		}
+
+		if result.BuildNumber > r.seenBuildNums[result.PlanKey] {
+			r.resultsTotal.WithLabelValues(result.PlanKey, result.State).Inc()
+		}
+	}
+
+	for plan, result := range latestByPlan {
+		if result.BuildNumber > r.seenBuildNums[plan] {
+			r.seenBuildNums[plan] = result.BuildNumber
+		}
+
+		r.lastDuration.WithLabelValues(result.PlanKey, result.ProjectKey).Set(result.BuildDurationInSeconds)
+
+		ch <- prometheus.MustNewConstMetric(
+			buildLastStatus, prometheus.GaugeValue, 1,
+			result.PlanKey, result.ProjectKey, result.State,
+		)
+	}
+
+	for plan, completed := range latestSuccessByPlan {
+		project := latestByPlan[plan].ProjectKey
+		r.lastSuccess.WithLabelValues(plan, project).Set(float64(completed.Unix()))
+	}
+
+	r.lastDuration.Collect(ch)
+	r.lastSuccess.Collect(ch)
+	r.resultsTotal.Collect(ch)
+}
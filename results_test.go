@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func drain(ch chan prometheus.Metric) {
+	for range ch {
+	}
+}
+
+func TestResultsCollectorObserveCountsEachBuildOnce(t *testing.T) {
+	r := newResultsCollector(true, 0, 50, nil)
+
+	page := BambooResults{}
+	page.Results.Result = []BambooResult{
+		{PlanKey: "PROJ-PLAN", ProjectKey: "PROJ", State: "Successful", BuildNumber: 1, BuildDurationInSeconds: 10},
+	}
+
+	ch := make(chan prometheus.Metric, 64)
+	go drain(ch)
+	r.observe(ch, page)
+	close(ch)
+
+	if got := testutil.ToFloat64(r.resultsTotal.WithLabelValues("PROJ-PLAN", "Successful")); got != 1 {
+		t.Fatalf("expected results_total to be 1 after first observe, got %v", got)
+	}
+
+	ch = make(chan prometheus.Metric, 64)
+	go drain(ch)
+	r.observe(ch, page)
+	close(ch)
+
+	if got := testutil.ToFloat64(r.resultsTotal.WithLabelValues("PROJ-PLAN", "Successful")); got != 1 {
+		t.Fatalf("expected results_total to stay 1 after re-observing the same build, got %v", got)
+	}
+
+	page.Results.Result[0].BuildNumber = 2
+
+	ch = make(chan prometheus.Metric, 64)
+	go drain(ch)
+	r.observe(ch, page)
+	close(ch)
+
+	if got := testutil.ToFloat64(r.resultsTotal.WithLabelValues("PROJ-PLAN", "Successful")); got != 2 {
+		t.Fatalf("expected results_total to be 2 after a new build number, got %v", got)
+	}
+}
+
+func TestResultsCollectorObserveMaxAgeFiltersOldBuilds(t *testing.T) {
+	r := newResultsCollector(true, time.Hour, 50, nil)
+
+	page := BambooResults{}
+	page.Results.Result = []BambooResult{
+		{
+			PlanKey:            "PROJ-PLAN",
+			ProjectKey:         "PROJ",
+			State:              "Successful",
+			BuildNumber:        1,
+			BuildCompletedTime: time.Now().Add(-48 * time.Hour).Format(bambooTimeLayout),
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 64)
+	go drain(ch)
+	r.observe(ch, page)
+	close(ch)
+
+	if got := testutil.ToFloat64(r.resultsTotal.WithLabelValues("PROJ-PLAN", "Successful")); got != 0 {
+		t.Fatalf("expected build older than max-age to be skipped, got count %v", got)
+	}
+}
+
+func TestBambooResultUnmarshalsISO8601CompletedTime(t *testing.T) {
+	// A trimmed-down real /rest/api/latest/result response, to catch
+	// regressions in BambooResult's field types against Bamboo's actual JSON.
+	const fixture = `{
+		"results": {
+			"size": 1,
+			"result": [
+				{
+					"key": "PROJ-PLAN-1",
+					"planName": "Plan",
+					"projectKey": "PROJ",
+					"projectName": "Project",
+					"state": "Successful",
+					"buildNumber": 1,
+					"buildDurationInSeconds": 42,
+					"buildCompletedTime": "2016-05-24T13:05:00.000+02:00"
+				}
+			]
+		}
+	}`
+
+	var page BambooResults
+	if err := json.Unmarshal([]byte(fixture), &page); err != nil {
+		t.Fatalf("failed to unmarshal a real Bamboo results response: %v", err)
+	}
+
+	result := page.Results.Result[0]
+	completed := result.completedTime()
+	if completed.IsZero() {
+		t.Fatal("expected completedTime() to parse buildCompletedTime, got the zero time")
+	}
+
+	want := time.Date(2016, 5, 24, 13, 5, 0, 0, time.FixedZone("", 2*60*60))
+	if !completed.Equal(want) {
+		t.Fatalf("expected completedTime() to be %v, got %v", want, completed)
+	}
+}
+
+func TestResultsCollectorObservePlanFilter(t *testing.T) {
+	r := newResultsCollector(true, 0, 50, regexp.MustCompile("^PROJ-"))
+
+	page := BambooResults{}
+	page.Results.Result = []BambooResult{
+		{PlanKey: "PROJ-PLAN", ProjectKey: "PROJ", State: "Successful", BuildNumber: 1},
+		{PlanKey: "OTHER-PLAN", ProjectKey: "OTHER", State: "Successful", BuildNumber: 1},
+	}
+
+	ch := make(chan prometheus.Metric, 64)
+	go drain(ch)
+	r.observe(ch, page)
+	close(ch)
+
+	if got := testutil.ToFloat64(r.resultsTotal.WithLabelValues("PROJ-PLAN", "Successful")); got != 1 {
+		t.Fatalf("expected matching plan to be counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(r.resultsTotal.WithLabelValues("OTHER-PLAN", "Successful")); got != 0 {
+		t.Fatalf("expected non-matching plan to be filtered out, got %v", got)
+	}
+}
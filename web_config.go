@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// WebConfig describes the structure of the --web.config.file YAML document,
+// mirroring the shape used by prometheus/exporter-toolkit.
+type WebConfig struct {
+	TLSConfig struct {
+		CertFile     string   `yaml:"cert_file"`
+		KeyFile      string   `yaml:"key_file"`
+		ClientCAs    string   `yaml:"client_ca_file"`
+		ClientAuth   string   `yaml:"client_auth_type"`
+		CipherSuites []string `yaml:"cipher_suites"`
+	} `yaml:"tls_server_config"`
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+// loadWebConfig reads and parses the web config file at path.
+func loadWebConfig(path string) (*WebConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &WebConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// clientAuthTypes maps the YAML client_auth_type string onto tls.ClientAuthType.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// cipherSuites maps the supported cipher suite names onto their tls.CipherSuite IDs.
+var cipherSuites = func() map[string]uint16 {
+	suites := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	return suites
+}()
+
+// tlsConfigMinVersions maps the --web.tls-min-version flag value onto tls.Config.MinVersion.
+var tlsConfigMinVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// basicAuthMiddleware validates an incoming request's Authorization header
+// against the bcrypt-hashed users configured in the web config file before
+// passing the request on to next.
+func basicAuthMiddleware(next http.Handler, users map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		hash, userOK := users[user]
+
+		if !ok || !userOK || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bamboo_exporter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// webConfigServer serves the mux over TLS/basic-auth when a web config file
+// is supplied, reloading its TLS certificate on SIGHUP, or falls back to a
+// plain http.ListenAndServe otherwise.
+type webConfigServer struct {
+	listenAddress string
+	configFile    string
+	tlsMinVersion string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (s *webConfigServer) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cert, nil
+}
+
+func (s *webConfigServer) loadCertificate(config *WebConfig) error {
+	cert, err := tls.LoadX509KeyPair(config.TLSConfig.CertFile, config.TLSConfig.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.cert = &cert
+	s.mu.Unlock()
+
+	return nil
+}
+
+// loadCertPool reads a PEM-encoded certificate bundle from path into a
+// fresh x509.CertPool, for use as the client CA pool during mTLS.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + path)
+	}
+
+	return pool, nil
+}
+
+// watchReload reloads the TLS certificate whenever the process receives SIGHUP.
+func (s *webConfigServer) watchReload(config *WebConfig) {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	for range reload {
+		if err := s.loadCertificate(config); err != nil {
+			log.Errorf("failed to reload web config TLS certificate: %v", err)
+
+			continue
+		}
+		log.Infoln("reloaded web config TLS certificate")
+	}
+}
+
+// listenAndServe serves mux on s.listenAddress, using TLS and basic-auth as
+// described by the web config file when s.configFile is set.
+func (s *webConfigServer) listenAndServe(mux http.Handler) error {
+	if s.configFile == "" {
+		return http.ListenAndServe(s.listenAddress, mux)
+	}
+
+	config, err := loadWebConfig(s.configFile)
+	if err != nil {
+		return err
+	}
+
+	if len(config.BasicAuthUsers) > 0 {
+		mux = basicAuthMiddleware(mux, config.BasicAuthUsers)
+	}
+
+	if config.TLSConfig.CertFile == "" || config.TLSConfig.KeyFile == "" {
+		return http.ListenAndServe(s.listenAddress, mux)
+	}
+
+	clientAuth, ok := clientAuthTypes[config.TLSConfig.ClientAuth]
+	if !ok {
+		return fmt.Errorf("unknown client_auth_type %q", config.TLSConfig.ClientAuth)
+	}
+
+	minVersion, ok := tlsConfigMinVersions[s.tlsMinVersion]
+	if !ok {
+		return fmt.Errorf("unknown tls-min-version %q", s.tlsMinVersion)
+	}
+
+	var cipherSuiteIDs []uint16
+	for _, name := range config.TLSConfig.CipherSuites {
+		id, ok := cipherSuites[name]
+		if !ok {
+			return fmt.Errorf("unknown cipher suite %q", name)
+		}
+		cipherSuiteIDs = append(cipherSuiteIDs, id)
+	}
+
+	if err := s.loadCertificate(config); err != nil {
+		return err
+	}
+	go s.watchReload(config)
+
+	tlsConfig := &tls.Config{
+		GetCertificate: s.getCertificate,
+		ClientAuth:     clientAuth,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuiteIDs,
+	}
+
+	if config.TLSConfig.ClientCAs != "" {
+		caPool, err := loadCertPool(config.TLSConfig.ClientCAs)
+		if err != nil {
+			return err
+		}
+		tlsConfig.ClientCAs = caPool
+	}
+
+	server := &http.Server{
+		Addr:      s.listenAddress,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	return server.ListenAndServeTLS("", "")
+}
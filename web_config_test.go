@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestListenAndServeRejectsUnknownClientAuthType(t *testing.T) {
+	dir := t.TempDir()
+	configFile := dir + "/web-config.yml"
+	writeWebConfigFixture(t, configFile, `
+tls_server_config:
+  cert_file: does-not-matter.pem
+  key_file: does-not-matter.key
+  client_auth_type: NotARealClientAuthType
+`)
+
+	s := &webConfigServer{configFile: configFile, tlsMinVersion: "TLS12"}
+	if err := s.listenAndServe(nil); err == nil {
+		t.Fatal("expected an error for an unknown client_auth_type, got nil")
+	}
+}
+
+func TestListenAndServeRejectsUnknownTLSMinVersion(t *testing.T) {
+	dir := t.TempDir()
+	configFile := dir + "/web-config.yml"
+	writeWebConfigFixture(t, configFile, `
+tls_server_config:
+  cert_file: does-not-matter.pem
+  key_file: does-not-matter.key
+`)
+
+	s := &webConfigServer{configFile: configFile, tlsMinVersion: "NotARealVersion"}
+	if err := s.listenAndServe(nil); err == nil {
+		t.Fatal("expected an error for an unknown tls-min-version, got nil")
+	}
+}
+
+func TestListenAndServeRejectsUnknownCipherSuite(t *testing.T) {
+	dir := t.TempDir()
+	configFile := dir + "/web-config.yml"
+	writeWebConfigFixture(t, configFile, `
+tls_server_config:
+  cert_file: does-not-matter.pem
+  key_file: does-not-matter.key
+  cipher_suites:
+    - NotARealCipherSuite
+`)
+
+	s := &webConfigServer{configFile: configFile, tlsMinVersion: "TLS12"}
+	if err := s.listenAndServe(nil); err == nil {
+		t.Fatal("expected an error for an unknown cipher suite, got nil")
+	}
+}
+
+func TestWebConfigServerLoadsValidCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := dir + "/cert.pem"
+	keyFile := dir + "/key.pem"
+	writeSelfSignedCertFixture(t, certFile, keyFile)
+
+	s := &webConfigServer{}
+	config := &WebConfig{}
+	config.TLSConfig.CertFile = certFile
+	config.TLSConfig.KeyFile = keyFile
+
+	if err := s.loadCertificate(config); err != nil {
+		t.Fatalf("expected a valid cert/key pair to load, got: %v", err)
+	}
+
+	cert, err := s.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("getCertificate returned an error: %v", err)
+	}
+	if cert == nil {
+		t.Fatal("expected getCertificate to return the loaded certificate, got nil")
+	}
+}
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash test password: %v", err)
+	}
+	users := map[string]string{"alice": string(hash)}
+
+	handler := basicAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), users)
+
+	cases := []struct {
+		name       string
+		user, pass string
+		setAuth    bool
+		wantStatus int
+	}{
+		{"valid credentials", "alice", "correct-horse", true, http.StatusOK},
+		{"wrong password", "alice", "wrong-password", true, http.StatusUnauthorized},
+		{"unknown user", "bob", "correct-horse", true, http.StatusUnauthorized},
+		{"missing header", "", "", false, http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tc.setAuth {
+				req.SetBasicAuth(tc.user, tc.pass)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func writeWebConfigFixture(t *testing.T, path, contents string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write web config fixture: %v", err)
+	}
+}
+
+// writeSelfSignedCertFixture writes a throwaway self-signed cert/key pair to
+// certFile/keyFile, for tests that exercise TLS certificate loading.
+func writeSelfSignedCertFixture(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "bamboo_exporter test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %v", err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write test certificate: %v", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal test key: %v", err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0o600); err != nil {
+		t.Fatalf("failed to write test key: %v", err)
+	}
+}